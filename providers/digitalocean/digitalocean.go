@@ -2,23 +2,70 @@ package digitalocean
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	api "github.com/digitalocean/godo"
+	"golang.org/x/net/idna"
 	"golang.org/x/oauth2"
 
-	"github.com/juju/ratelimit"
 	"github.com/rancher/external-dns/providers"
+	"github.com/rancher/external-dns/providers/ratelimited"
 	"github.com/rancher/external-dns/utils"
 )
 
+// DO's API is rate limited at 5000/hour. This is enforced by wrapping the
+// godo HTTP client's transport in providers/ratelimited, so every HTTP
+// request a call makes -- including the ones hidden inside a paged list or
+// a loop of per-value creates -- takes a token, not just once per
+// Add/Update/Remove/GetRecords call.
+const (
+	doQPS   = 5000.0 / 3600.0
+	doBurst = 1
+)
+
+// Config holds everything needed to construct a DigitalOceanProvider. It is
+// split out from the provider itself so tests (and alternate DO-compatible
+// endpoints) can supply their own HTTPClient/BaseURL without going through
+// the environment.
+type Config struct {
+	BaseURL     string
+	AuthToken   string
+	TTL         int
+	HTTPTimeout time.Duration
+	HTTPClient  *http.Client
+}
+
+const (
+	defaultHTTPTimeout = 10 * time.Second
+
+	// minTTL is DigitalOcean's documented minimum record TTL.
+	minTTL = 30
+)
+
 type DigitalOceanProvider struct {
 	client         *api.Client
 	rootDomainName string
 	TTL            int
-	limiter        *ratelimit.Bucket
+
+	// recordIDsMu guards recordIDs and recordIDsWarm, a fqdn -> type ->
+	// record ID cache that lets RemoveRecord/UpdateRecord skip the O(N)
+	// paged list scan once warm. It is populated wholesale by
+	// refreshRecordCache (used by GetRecords and while the cache is not yet
+	// warm; a miss on a warm cache does not refetch) and kept up
+	// to date incrementally by AddRecord/UpdateRecord/RemoveRecord.
+	// recordIDsWarm is tracked separately from recordIDs == nil because
+	// AddRecord can populate a partial cache (via cacheAddRecord) before
+	// the first full fetch ever runs.
+	recordIDsMu   sync.Mutex
+	recordIDs     map[string]map[string][]int
+	recordIDsWarm bool
 }
 
 func init() {
@@ -36,40 +83,115 @@ func (t *TokenSource) Token() (*oauth2.Token, error) {
 	return token, nil
 }
 
-func (p *DigitalOceanProvider) Init(rootDomainName string) error {
-	var pat string
-	if pat = os.Getenv("DO_PAT"); len(pat) == 0 {
-		return fmt.Errorf("DO_PAT is not set")
+// NewDigitalOceanProviderFromEnv builds a DigitalOceanProvider's Config from
+// DO_PAT, DO_API_URL, DO_TTL, and DO_HTTP_TIMEOUT, then constructs the
+// provider. It does not perform any network I/O; callers still need to call
+// Init (or Configure) with the root domain.
+func NewDigitalOceanProviderFromEnv() (*DigitalOceanProvider, error) {
+	pat := os.Getenv("DO_PAT")
+	if len(pat) == 0 {
+		return nil, fmt.Errorf("DO_PAT is not set")
 	}
 
-	tokenSource := &TokenSource{
-		AccessToken: pat,
+	config := Config{
+		BaseURL:     os.Getenv("DO_API_URL"),
+		AuthToken:   pat,
+		HTTPTimeout: defaultHTTPTimeout,
 	}
 
-	oauthClient := oauth2.NewClient(oauth2.NoContext, tokenSource)
-	p.client = api.NewClient(oauthClient)
+	if ttl := os.Getenv("DO_TTL"); len(ttl) > 0 {
+		parsed, err := strconv.Atoi(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("DO_TTL must be an integer number of seconds: %v", err)
+		}
+		config.TTL = parsed
+	}
 
-	// DO's API is rate limited at 5000/hour.
-	doqps := (float64)(5000.0 / 3600.0)
-	p.limiter = ratelimit.NewBucketWithRate(doqps, 1)
+	if timeout := os.Getenv("DO_HTTP_TIMEOUT"); len(timeout) > 0 {
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil {
+			// Also accept a bare number of seconds for convenience.
+			secs, secErr := strconv.Atoi(timeout)
+			if secErr != nil {
+				return nil, fmt.Errorf("DO_HTTP_TIMEOUT must be a duration (e.g. \"10s\"): %v", err)
+			}
+			parsed = time.Duration(secs) * time.Second
+		}
+		config.HTTPTimeout = parsed
+	}
+
+	return NewDigitalOceanProvider(config)
+}
 
-	p.rootDomainName = utils.UnFqdn(rootDomainName)
+// NewDigitalOceanProvider constructs a DigitalOceanProvider from an explicit
+// Config. Callers that need a custom *http.Client (a corporate proxy, an
+// httptest server, an alternate DO-compatible endpoint) should set it on the
+// Config rather than going through the environment.
+func NewDigitalOceanProvider(config Config) (*DigitalOceanProvider, error) {
+	if len(config.AuthToken) == 0 {
+		return nil, fmt.Errorf("AuthToken is required")
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		tokenSource := &TokenSource{AccessToken: config.AuthToken}
+		httpClient = oauth2.NewClient(oauth2.NoContext, tokenSource)
+	}
+	if httpClient.Timeout == 0 {
+		httpClient.Timeout = config.HTTPTimeout
+	}
+	httpClient.Transport = ratelimited.NewRoundTripper(httpClient.Transport, doQPS, doBurst)
+
+	client := api.NewClient(httpClient)
+	if len(config.BaseURL) > 0 {
+		baseURL, err := url.Parse(config.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("DO_API_URL is not a valid URL: %v", err)
+		}
+		client.BaseURL = baseURL
+	}
+
+	return &DigitalOceanProvider{
+		client: client,
+		TTL:    config.TTL,
+	}, nil
+}
+
+// Init remains a thin wrapper around NewDigitalOceanProviderFromEnv for
+// callers (and the provider registry) that only know the root domain name.
+func (p *DigitalOceanProvider) Init(rootDomainName string) error {
+	configured, err := NewDigitalOceanProviderFromEnv()
+	if err != nil {
+		return err
+	}
+	// Copy fields individually rather than `*p = *configured` -- p may
+	// already carry a recordIDsMu, and copying the whole struct would trip
+	// go vet's copylocks check.
+	p.client = configured.client
+	p.TTL = configured.TTL
+
+	ace, err := toACE(utils.UnFqdn(rootDomainName))
+	if err != nil {
+		return fmt.Errorf("%s is not a valid domain name: %v", rootDomainName, err)
+	}
+	p.rootDomainName = ace
 
 	// Retrieve email address associated with this PAT.
-	p.limiter.Wait(1)
 	acct, _, err := p.client.Account.Get()
 	if err != nil {
 		return err
 	}
 
 	// Now confirm that domain is accessible under this PAT.
-	p.limiter.Wait(1)
 	domains, _, err := p.client.Domains.Get(p.rootDomainName)
 	if err != nil {
 		return err
 	}
-	// DO's TTLs are domain-wide.
-	p.TTL = domains.TTL
+	// The domain TTL is the default for records without their own TTL; only
+	// take it when the config (and thus DO_TTL) didn't already pin one.
+	if p.TTL == 0 {
+		p.TTL = domains.TTL
+	}
 
 	logrus.Infof("Configured %s for email %s and domain %s", p.GetName(), acct.Email, domains.Name)
 
@@ -81,105 +203,300 @@ func (p *DigitalOceanProvider) GetName() string {
 }
 
 func (p *DigitalOceanProvider) HealthCheck() error {
-	p.limiter.Wait(1)
 	_, _, err := p.client.Domains.Get(p.rootDomainName)
 	return err
 }
 
+// toACE converts a zone or record name to its ACE (punycode) form, the only
+// form DO's API accepts, preserving a trailing dot if present.
+func toACE(name string) (string, error) {
+	trimmed := strings.TrimSuffix(name, ".")
+	ace, err := idna.ToASCII(trimmed)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(name, ".") {
+		return ace + ".", nil
+	}
+	return ace, nil
+}
+
+// toUnicode converts an ACE zone or record name back to Unicode for display
+// and for the DnsRecord values handed back to callers. Names DO returns are
+// always valid ACE, so a conversion failure here would indicate a bug
+// upstream rather than bad user input -- fall back to the ACE form rather
+// than erroring.
+func toUnicode(name string) string {
+	trimmed := strings.TrimSuffix(name, ".")
+	u, err := idna.ToUnicode(trimmed)
+	if err != nil {
+		return name
+	}
+	if strings.HasSuffix(name, ".") {
+		return u + "."
+	}
+	return u
+}
+
+// fqdnForRecord turns a raw DO record name ("@" or a subdomain label) into
+// the fully qualified, Unicode name GetRecords reports to callers, so cache
+// keys and returned DnsRecord.Fqdn values always agree.
+func (p *DigitalOceanProvider) fqdnForRecord(name string) string {
+	var full string
+	if name == "@" {
+		full = p.rootDomainName
+	} else {
+		full = strings.Join([]string{name, p.rootDomainName}, ".")
+	}
+	return utils.Fqdn(toUnicode(full))
+}
+
+// refreshRecordCache does one paged fetch of every record in the zone,
+// rebuilding recordIDs from scratch, and returns the raw records so
+// GetRecords can reuse the same fetch instead of doing it twice.
+func (p *DigitalOceanProvider) refreshRecordCache() ([]api.DomainRecord, error) {
+	var all []api.DomainRecord
+	cache := map[string]map[string][]int{}
+	opt := &api.ListOptions{}
+	for {
+		drecords, resp, err := p.client.Domains.Records(p.rootDomainName, opt)
+		if err != nil {
+			return nil, fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
+		}
+		for _, r := range drecords {
+			fqdn := p.fqdnForRecord(r.Name)
+			byType, exists := cache[fqdn]
+			if !exists {
+				byType = map[string][]int{}
+				cache[fqdn] = byType
+			}
+			byType[r.Type] = append(byType[r.Type], r.ID)
+			all = append(all, r)
+		}
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
+		}
+		opt.Page = page + 1
+	}
+
+	p.recordIDsMu.Lock()
+	p.recordIDs = cache
+	p.recordIDsWarm = true
+	p.recordIDsMu.Unlock()
+
+	return all, nil
+}
+
+// recordIDsFor returns the cached DO record IDs for fqdn/recordType,
+// warming the cache with a single paged fetch first if it hasn't been
+// populated yet.
+func (p *DigitalOceanProvider) recordIDsFor(fqdn, recordType string) ([]int, error) {
+	p.recordIDsMu.Lock()
+	warm := p.recordIDsWarm
+	p.recordIDsMu.Unlock()
+
+	if !warm {
+		if _, err := p.refreshRecordCache(); err != nil {
+			return nil, err
+		}
+	}
+
+	p.recordIDsMu.Lock()
+	defer p.recordIDsMu.Unlock()
+	return append([]int(nil), p.recordIDs[fqdn][recordType]...), nil
+}
+
+func (p *DigitalOceanProvider) cacheAddRecord(fqdn, recordType string, id int) {
+	p.recordIDsMu.Lock()
+	defer p.recordIDsMu.Unlock()
+	if p.recordIDs == nil {
+		p.recordIDs = map[string]map[string][]int{}
+	}
+	byType, exists := p.recordIDs[fqdn]
+	if !exists {
+		byType = map[string][]int{}
+		p.recordIDs[fqdn] = byType
+	}
+	byType[recordType] = append(byType[recordType], id)
+}
+
+func (p *DigitalOceanProvider) cacheRemoveRecord(fqdn, recordType string, id int) {
+	p.recordIDsMu.Lock()
+	defer p.recordIDsMu.Unlock()
+	byType, exists := p.recordIDs[fqdn]
+	if !exists {
+		return
+	}
+	ids := byType[recordType]
+	for i, existingID := range ids {
+		if existingID == id {
+			byType[recordType] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// validateTTL rejects TTLs below DO's documented minimum. A zero TTL is
+// allowed through -- it means "fall back to the domain TTL".
+func validateTTL(ttl int) error {
+	if ttl != 0 && ttl < minTTL {
+		return fmt.Errorf("TTL of %ds is below DigitalOcean's minimum of %ds", ttl, minTTL)
+	}
+	return nil
+}
+
 func (p *DigitalOceanProvider) AddRecord(record utils.DnsRecord) error {
 	logrus.Debugf("AddRecord")
+	if err := validateTTL(record.TTL); err != nil {
+		return err
+	}
+	aceName, err := toACE(record.Fqdn)
+	if err != nil {
+		return fmt.Errorf("%s: invalid record name %q: %v", p.GetName(), record.Fqdn, err)
+	}
 	for _, r := range record.Records {
 		createRequest := &api.DomainRecordEditRequest{
 			Type: record.Type,
-			Name: record.Fqdn,
+			Name: aceName,
 			Data: r,
+			TTL:  record.TTL,
 		}
 		logrus.Debugf(" request: %v", createRequest)
-		p.limiter.Wait(1)
 		rec, _, err := p.client.Domains.CreateRecord(p.rootDomainName, createRequest)
 		if err != nil {
 			return fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
 		}
 		logrus.Debugf(" rec: %v", rec)
+		p.cacheAddRecord(record.Fqdn, rec.Type, rec.ID)
 	}
 	return nil
 }
 
+// UpdateRecord edits existing remote records in place via EditRecord rather
+// than deleting and recreating them, so records don't disappear for the
+// duration of the update. Surplus remote records are deleted and any extra
+// desired records are created. If EditRecord turns out not to be usable for
+// a record (e.g. a type mismatch), it falls back to the old remove-then-add
+// behavior.
 func (p *DigitalOceanProvider) UpdateRecord(record utils.DnsRecord) error {
 	logrus.Debugf("UpdateRecord")
-	if err := p.RemoveRecord(record); err != nil {
+	if err := validateTTL(record.TTL); err != nil {
+		return err
+	}
+	aceName, err := toACE(record.Fqdn)
+	if err != nil {
+		return fmt.Errorf("%s: invalid record name %q: %v", p.GetName(), record.Fqdn, err)
+	}
+	ids, err := p.recordIDsFor(record.Fqdn, record.Type)
+	if err != nil {
 		return err
 	}
-	return p.AddRecord(record)
+
+	if len(ids) == 0 {
+		return p.AddRecord(record)
+	}
+
+	editCount := len(ids)
+	if len(record.Records) < editCount {
+		editCount = len(record.Records)
+	}
+
+	for i := 0; i < editCount; i++ {
+		editRequest := &api.DomainRecordEditRequest{
+			Type: record.Type,
+			Name: aceName,
+			Data: record.Records[i],
+			TTL:  record.TTL,
+		}
+		logrus.Debugf(" edit request: %v", editRequest)
+		rec, _, err := p.client.Domains.EditRecord(p.rootDomainName, ids[i], editRequest)
+		if err != nil {
+			logrus.Warnf("%s EditRecord has failed, falling back to remove+add: %v", p.GetName(), err)
+			if err := p.RemoveRecord(record); err != nil {
+				return err
+			}
+			return p.AddRecord(record)
+		}
+		logrus.Debugf(" rec: %v", rec)
+	}
+
+	for _, id := range ids[editCount:] {
+		if _, err := p.client.Domains.DeleteRecord(p.rootDomainName, id); err != nil {
+			return fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
+		}
+		p.cacheRemoveRecord(record.Fqdn, record.Type, id)
+	}
+
+	if len(record.Records) > editCount {
+		extra := utils.DnsRecord{Fqdn: record.Fqdn, Type: record.Type, TTL: record.TTL, Records: record.Records[editCount:]}
+		return p.AddRecord(extra)
+	}
+
+	return nil
 }
 
 func (p *DigitalOceanProvider) RemoveRecord(record utils.DnsRecord) error {
 	logrus.Debugf("RemoveRecord")
-	p.limiter.Wait(1)
-	records, _, err := p.client.Domains.Records(p.rootDomainName, nil)
+	ids, err := p.recordIDsFor(record.Fqdn, record.Type)
 	if err != nil {
 		return err
 	}
-	for _, rec := range records {
-		if rec.Name == record.Fqdn && rec.Type == record.Type {
-			p.limiter.Wait(1)
-			_, err := p.client.Domains.DeleteRecord(p.rootDomainName, rec.ID)
-			if err != nil {
-				return fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
-			}
+	for _, id := range ids {
+		if _, err := p.client.Domains.DeleteRecord(p.rootDomainName, id); err != nil {
+			return fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
 		}
+		p.cacheRemoveRecord(record.Fqdn, record.Type, id)
 	}
-	return err
+	return nil
+}
+
+// recordGroup accumulates the data values and TTL for one fqdn/type pair
+// while GetRecords walks the zone.
+type recordGroup struct {
+	Records []string
+	TTL     int
 }
 
 func (p *DigitalOceanProvider) GetRecords() ([]utils.DnsRecord, error) {
 	dnsRecords := []utils.DnsRecord{}
-	recordMap := map[string]map[string][]string{}
-	opt := &api.ListOptions{}
-	for {
-		p.limiter.Wait(1)
-		drecords, resp, err := p.client.Domains.Records(p.rootDomainName, opt)
-		if err != nil {
-			return nil, fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
-		}
-		for _, r := range drecords {
-			if r.Name == "@" {
-				logrus.Debugf("caught @")
-				r.Name = p.rootDomainName
-			} else {
-				names := []string{r.Name, p.rootDomainName}
-				r.Name = strings.Join(names, ".")
-			}
-			fqdn := utils.Fqdn(r.Name)
-			recordSet, exists := recordMap[fqdn]
-			if exists {
-				recordSlice, sliceExists := recordSet[r.Type]
-				if sliceExists {
-					recordSlice = append(recordSlice, r.Data)
-					recordSet[r.Type] = recordSlice
-				} else {
-					recordSet[r.Type] = []string{r.Data}
-				}
-			} else {
-				recordMap[fqdn] = map[string][]string{}
-				recordMap[fqdn][r.Type] = []string{r.Data}
-			}
+	recordMap := map[string]map[string]*recordGroup{}
+
+	drecords, err := p.refreshRecordCache()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range drecords {
+		fqdn := p.fqdnForRecord(r.Name)
+		recordSet, exists := recordMap[fqdn]
+		if !exists {
+			recordSet = map[string]*recordGroup{}
+			recordMap[fqdn] = recordSet
 		}
-		if resp.Links == nil || resp.Links.IsLastPage() {
-			break
+		group, exists := recordSet[r.Type]
+		if !exists {
+			group = &recordGroup{}
+			recordSet[r.Type] = group
 		}
-		page, err := resp.Links.CurrentPage()
-		if err != nil {
-			return nil, fmt.Errorf("%s API call has failed: %v", p.GetName(), err)
+		group.Records = append(group.Records, r.Data)
+		if group.TTL == 0 {
+			group.TTL = r.TTL
 		}
-		opt.Page = page + 1
 	}
 
 	logrus.Debugf("recordSet")
 	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			// Digital Ocean does not have per-record TTLs.
-			dnsRecord := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: p.TTL}
+		for recordType, group := range recordSet {
+			// Fall back to the domain TTL only when DO reports no
+			// record-level TTL for this record.
+			ttl := group.TTL
+			if ttl == 0 {
+				ttl = p.TTL
+			}
+			dnsRecord := utils.DnsRecord{Fqdn: fqdn, Records: group.Records, Type: recordType, TTL: ttl}
 			logrus.Debugf(" %v", dnsRecord)
 			dnsRecords = append(dnsRecords, dnsRecord)
 		}
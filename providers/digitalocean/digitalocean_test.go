@@ -0,0 +1,88 @@
+package digitalocean
+
+import "testing"
+
+func TestToACE(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ascii passthrough", "example.com", "example.com"},
+		{"ascii passthrough with trailing dot", "example.com.", "example.com."},
+		{"unicode zone", "café.example", "xn--caf-dma.example"},
+		{"unicode zone with trailing dot", "café.example.", "xn--caf-dma.example."},
+		{"unicode record under ascii zone", "café.example.com", "xn--caf-dma.example.com"},
+	}
+	for _, c := range cases {
+		got, err := toACE(c.in)
+		if err != nil {
+			t.Errorf("%s: toACE(%q) returned error: %v", c.name, c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: toACE(%q) = %q, want %q", c.name, c.in, got, c.want)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ascii passthrough", "example.com", "example.com"},
+		{"punycode zone", "xn--caf-dma.example", "café.example"},
+		{"punycode zone with trailing dot", "xn--caf-dma.example.", "café.example."},
+	}
+	for _, c := range cases {
+		got := toUnicode(c.in)
+		if got != c.want {
+			t.Errorf("%s: toUnicode(%q) = %q, want %q", c.name, c.in, got, c.want)
+		}
+	}
+}
+
+func TestValidateTTL(t *testing.T) {
+	cases := []struct {
+		name    string
+		ttl     int
+		wantErr bool
+	}{
+		{"zero falls back to domain TTL", 0, false},
+		{"at minimum", minTTL, false},
+		{"above minimum", 3600, false},
+		{"below minimum", minTTL - 1, true},
+		{"negative", -1, true},
+	}
+	for _, c := range cases {
+		err := validateTTL(c.ttl)
+		if c.wantErr && err == nil {
+			t.Errorf("%s: validateTTL(%d) = nil, want error", c.name, c.ttl)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: validateTTL(%d) = %v, want nil", c.name, c.ttl, err)
+		}
+	}
+}
+
+func TestFqdnForRecord(t *testing.T) {
+	p := &DigitalOceanProvider{rootDomainName: "xn--caf-dma.example"}
+
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"apex", "@", "café.example."},
+		{"subdomain", "www", "www.café.example."},
+		{"unicode subdomain", "xn--mnchen-3ya", "münchen.café.example."},
+	}
+	for _, c := range cases {
+		got := p.fqdnForRecord(c.in)
+		if got != c.want {
+			t.Errorf("%s: fqdnForRecord(%q) = %q, want %q", c.name, c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package digitalocean
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDORecord is the subset of DO's domain record representation these
+// tests care about.
+type fakeDORecord struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl"`
+}
+
+// fakeDOServer is a minimal in-memory stand-in for DO's domain records API,
+// just enough of it to exercise AddRecord/UpdateRecord/RemoveRecord/
+// GetRecords against a real HTTP round trip via httptest. It matches on
+// HTTP method and whether the final path segment is a record ID, rather
+// than a fixed path, so it doesn't need to assume exactly which relative
+// URL the godo client builds.
+type fakeDOServer struct {
+	mu     sync.Mutex
+	nextID int
+	byID   map[int]*fakeDORecord
+}
+
+func newFakeDOServer(t *testing.T) (*httptest.Server, *fakeDOServer) {
+	fs := &fakeDOServer{nextID: 1, byID: map[int]*fakeDORecord{}}
+	ts := httptest.NewServer(http.HandlerFunc(fs.handle))
+	t.Cleanup(ts.Close)
+	return ts, fs
+}
+
+func (fs *fakeDOServer) seed(recType, name, data string, ttl int) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	id := fs.nextID
+	fs.nextID++
+	fs.byID[id] = &fakeDORecord{ID: id, Type: recType, Name: name, Data: data, TTL: ttl}
+	return id
+}
+
+func (fs *fakeDOServer) get(id int) (*fakeDORecord, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	rec, ok := fs.byID[id]
+	return rec, ok
+}
+
+func (fs *fakeDOServer) handle(w http.ResponseWriter, r *http.Request) {
+	trimmed := strings.TrimSuffix(r.URL.Path, "/")
+	segments := strings.Split(trimmed, "/")
+	id, isItem := 0, false
+	if len(segments) > 0 {
+		if parsed, err := strconv.Atoi(segments[len(segments)-1]); err == nil {
+			id, isItem = parsed, true
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !isItem:
+		fs.mu.Lock()
+		recs := make([]*fakeDORecord, 0, len(fs.byID))
+		for _, rec := range fs.byID {
+			recs = append(recs, rec)
+		}
+		fs.mu.Unlock()
+		writeJSON(w, map[string]interface{}{
+			"domain_records": recs,
+			"links":          map[string]interface{}{},
+			"meta":           map[string]interface{}{"total": len(recs)},
+		})
+	case r.Method == http.MethodPost && !isItem:
+		var body fakeDORecord
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newID := fs.seed(body.Type, body.Name, body.Data, body.TTL)
+		rec, _ := fs.get(newID)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]interface{}{"domain_record": rec})
+	case r.Method == http.MethodPut && isItem:
+		rec, ok := fs.get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var body fakeDORecord
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fs.mu.Lock()
+		rec.Data = body.Data
+		if body.TTL != 0 {
+			rec.TTL = body.TTL
+		}
+		fs.mu.Unlock()
+		writeJSON(w, map[string]interface{}{"domain_record": rec})
+	case r.Method == http.MethodDelete && isItem:
+		fs.mu.Lock()
+		delete(fs.byID, id)
+		fs.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,73 @@
+// Package ratelimited provides an http.RoundTripper that enforces a
+// token-bucket rate limit on every outgoing HTTP request, so a provider's
+// API client can be rate limited once at the transport level instead of
+// every provider carrying its own ad-hoc, per-call-site limiter
+// (DigitalOceanProvider used to, inline).
+package ratelimited
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/juju/ratelimit"
+)
+
+// RoundTripper wraps another http.RoundTripper, taking one token from a
+// shared bucket before every request reaches it. Because it sits at the
+// transport level, it throttles every HTTP call an API client makes --
+// including the ones hidden inside a single high-level method, such as a
+// paged list or a loop of per-value creates.
+type RoundTripper struct {
+	next    http.RoundTripper
+	limiter *ratelimit.Bucket
+
+	mu        sync.Mutex
+	calls     int64
+	waitTotal time.Duration
+}
+
+// NewRoundTripper wraps next (http.DefaultTransport if next is nil) with a
+// limiter that allows qps requests/sec with the given burst capacity.
+func NewRoundTripper(next http.RoundTripper, qps float64, burst int64) *RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RoundTripper{
+		next:    next,
+		limiter: ratelimit.NewBucketWithRate(qps, burst),
+	}
+}
+
+// RoundTrip waits for a token, then delegates to the wrapped transport.
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	before := time.Now()
+	rt.limiter.Wait(1)
+	waited := time.Since(before)
+
+	rt.mu.Lock()
+	rt.calls++
+	rt.waitTotal += waited
+	rt.mu.Unlock()
+
+	if waited > 0 {
+		logrus.Debugf("rate limiter blocked %v before %s %s", waited, req.Method, req.URL)
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+// Metrics is a snapshot of the limiter's observed behavior.
+type Metrics struct {
+	Calls     int64
+	WaitTotal time.Duration
+}
+
+// Metrics returns the number of requests made through the limiter so far
+// and the cumulative time spent waiting for a token.
+func (rt *RoundTripper) Metrics() Metrics {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return Metrics{Calls: rt.calls, WaitTotal: rt.waitTotal}
+}
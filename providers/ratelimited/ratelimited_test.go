@@ -0,0 +1,47 @@
+package ratelimited
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type countingTransport struct {
+	calls int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.calls++
+	return httptest.NewRecorder().Result(), nil
+}
+
+func TestRoundTripperDelegatesAndTracksMetrics(t *testing.T) {
+	next := &countingTransport{}
+	// A generous qps/burst keeps this test fast -- it isn't exercising the
+	// blocking behavior, just that every request is counted and passed
+	// through.
+	rt := NewRoundTripper(next, 1000, 10)
+
+	req := httptest.NewRequest("GET", "http://example.com/v2/domains/example.com/records", nil)
+	for i := 0; i < 3; i++ {
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip returned error: %v", err)
+		}
+	}
+
+	if next.calls != 3 {
+		t.Errorf("wrapped transport saw %d calls, want 3", next.calls)
+	}
+
+	metrics := rt.Metrics()
+	if metrics.Calls != 3 {
+		t.Errorf("Metrics().Calls = %d, want 3", metrics.Calls)
+	}
+}
+
+func TestNewRoundTripperDefaultsNilTransport(t *testing.T) {
+	rt := NewRoundTripper(nil, 1000, 10)
+	if rt.next == nil {
+		t.Fatal("NewRoundTripper(nil, ...) left next nil, want http.DefaultTransport")
+	}
+}